@@ -0,0 +1,200 @@
+/*
+Copyright 2016 Ted Elwartowski <xelwarto.pub@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package auth builds an authenticated compute.Service from whichever
+// credential source is available: a gcloud SDK account, a service-account
+// JSON key file, or Application Default Credentials.
+package auth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/compute/v1"
+
+	"github.com/xelwarto/gcloud-backup/transport"
+)
+
+// MaxRetries bounds how many times a single idempotent call is retried
+// by the transport installed in NewClient.
+const MaxRetries = 5
+
+// TokenSourceProvider produces an oauth2.TokenSource for a single
+// credential source.
+type TokenSourceProvider interface {
+	TokenSource(ctx context.Context) (oauth2.TokenSource, error)
+}
+
+// SDKProvider authenticates using a gcloud SDK account that has already
+// run `gcloud auth login` on the host.
+type SDKProvider struct {
+	Account string
+}
+
+func (p *SDKProvider) TokenSource(ctx context.Context) (oauth2.TokenSource, error) {
+	cfg, err := google.NewSDKConfig(p.Account)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.TokenSource(ctx), nil
+}
+
+// KeyFileProvider authenticates using a service-account JSON key file.
+type KeyFileProvider struct {
+	Path string
+}
+
+func (p *KeyFileProvider) TokenSource(ctx context.Context) (oauth2.TokenSource, error) {
+	data, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := google.JWTConfigFromJSON(data, compute.ComputeScope)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg.TokenSource(ctx), nil
+}
+
+// ADCProvider authenticates using Application Default Credentials, e.g.
+// the metadata server on GCE/Cloud Run/Cloud Build.
+type ADCProvider struct{}
+
+func (p *ADCProvider) TokenSource(ctx context.Context) (oauth2.TokenSource, error) {
+	creds, err := google.FindDefaultCredentials(ctx, compute.ComputeScope)
+	if err != nil {
+		return nil, err
+	}
+
+	return creds.TokenSource, nil
+}
+
+// reauthTokenSource wraps a TokenSourceProvider's oauth2.TokenSource so
+// that it can be force-refreshed on a 401. Each provider's TokenSource
+// (SDK config, JWT config, ADC) already caches the token it mints behind
+// an oauth2.ReuseTokenSource keyed on the token's own expiry, so simply
+// calling Token() again after a 401 just hands back the same
+// locally-still-valid cached token. Invalidate discards that cache by
+// re-deriving the token source from the provider, forcing the next
+// Token() call to mint a genuinely new one.
+type reauthTokenSource struct {
+	ctx      context.Context
+	provider TokenSourceProvider
+
+	mu  sync.Mutex
+	cur oauth2.TokenSource
+	err error
+}
+
+func newReauthTokenSource(ctx context.Context, provider TokenSourceProvider) (*reauthTokenSource, error) {
+	cur, err := provider.TokenSource(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &reauthTokenSource{ctx: ctx, provider: provider, cur: cur}, nil
+}
+
+func (r *reauthTokenSource) Token() (*oauth2.Token, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.cur.Token()
+}
+
+// Invalidate re-derives the token source from the provider, so the next
+// Token() call bypasses whatever cache the old one held.
+func (r *reauthTokenSource) Invalidate() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cur, err := r.provider.TokenSource(r.ctx)
+	if err != nil {
+		r.err = err
+		return
+	}
+	r.cur = cur
+	r.err = nil
+}
+
+// NewClient builds an authenticated *http.Client from the requested
+// credential source. When keyFile is set it takes precedence, then
+// useADC, then account. When none are set, each provider is tried in
+// turn -- SDK account, key file, ADC -- and the first one that succeeds
+// wins. compute.New and the storage client both build on top of this so
+// every GCP call in the tool shares one credential source and the same
+// logging/retrying transport.
+func NewClient(ctx context.Context, account string, keyFile string, useADC bool, verbose bool) (*http.Client, error) {
+	var providers []TokenSourceProvider
+
+	switch {
+	case keyFile != "":
+		providers = []TokenSourceProvider{&KeyFileProvider{Path: keyFile}}
+	case useADC:
+		providers = []TokenSourceProvider{&ADCProvider{}}
+	case account != "":
+		providers = []TokenSourceProvider{&SDKProvider{Account: account}}
+	default:
+		providers = []TokenSourceProvider{
+			&SDKProvider{Account: account},
+			&KeyFileProvider{Path: keyFile},
+			&ADCProvider{},
+		}
+	}
+
+	ts, err := firstTokenSource(ctx, providers)
+	if err != nil {
+		return nil, err
+	}
+
+	base := &oauth2.Transport{Source: ts}
+	rt := transport.New(base, ts, verbose, MaxRetries)
+	return &http.Client{Transport: rt}, nil
+}
+
+// firstTokenSource tries each provider in turn and returns the token
+// source of the first one that succeeds.
+func firstTokenSource(ctx context.Context, providers []TokenSourceProvider) (oauth2.TokenSource, error) {
+	var lastErr error
+	for _, p := range providers {
+		ts, err := newReauthTokenSource(ctx, p)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return ts, nil
+	}
+
+	return nil, fmt.Errorf("auth: no usable credentials found: %v", lastErr)
+}
+
+// NewService builds a compute.Service using the client returned by
+// NewClient.
+func NewService(ctx context.Context, account string, keyFile string, useADC bool, verbose bool) (*compute.Service, error) {
+	client, err := NewClient(ctx, account, keyFile, useADC, verbose)
+	if err != nil {
+		return nil, err
+	}
+
+	return compute.New(client)
+}
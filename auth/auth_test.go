@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"fmt"
+	"testing"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+// fakeProvider returns a fixed token source, or err if it is set.
+type fakeProvider struct {
+	name string
+	ts   oauth2.TokenSource
+	err  error
+}
+
+func (f *fakeProvider) TokenSource(ctx context.Context) (oauth2.TokenSource, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.ts, nil
+}
+
+type fakeTokenSource struct {
+	name string
+}
+
+func (f *fakeTokenSource) Token() (*oauth2.Token, error) {
+	return &oauth2.Token{AccessToken: f.name}, nil
+}
+
+func TestFirstTokenSourceTriesProvidersInOrder(t *testing.T) {
+	sdk := &fakeTokenSource{name: "sdk"}
+	keyFile := &fakeTokenSource{name: "key-file"}
+
+	providers := []TokenSourceProvider{
+		&fakeProvider{name: "sdk", err: fmt.Errorf("no gcloud config found")},
+		&fakeProvider{name: "key-file", ts: keyFile},
+		&fakeProvider{name: "adc", ts: sdk},
+	}
+
+	ts, err := firstTokenSource(context.Background(), providers)
+	if err != nil {
+		t.Fatalf("firstTokenSource: %v", err)
+	}
+
+	tok, err := ts.Token()
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if tok.AccessToken != "key-file" {
+		t.Errorf("AccessToken = %q, want %q (first provider to succeed, skipping the failed one)", tok.AccessToken, "key-file")
+	}
+}
+
+func TestFirstTokenSourcePrefersFirstProvider(t *testing.T) {
+	first := &fakeTokenSource{name: "first"}
+	second := &fakeTokenSource{name: "second"}
+
+	providers := []TokenSourceProvider{
+		&fakeProvider{name: "first", ts: first},
+		&fakeProvider{name: "second", ts: second},
+	}
+
+	ts, err := firstTokenSource(context.Background(), providers)
+	if err != nil {
+		t.Fatalf("firstTokenSource: %v", err)
+	}
+
+	tok, err := ts.Token()
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if tok.AccessToken != "first" {
+		t.Errorf("AccessToken = %q, want %q", tok.AccessToken, "first")
+	}
+}
+
+func TestFirstTokenSourceAllFail(t *testing.T) {
+	providers := []TokenSourceProvider{
+		&fakeProvider{name: "sdk", err: fmt.Errorf("sdk error")},
+		&fakeProvider{name: "key-file", err: fmt.Errorf("key-file error")},
+		&fakeProvider{name: "adc", err: fmt.Errorf("adc error")},
+	}
+
+	_, err := firstTokenSource(context.Background(), providers)
+	if err == nil {
+		t.Fatal("firstTokenSource: expected error, got none")
+	}
+}
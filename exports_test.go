@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunExports(t *testing.T) {
+	savedExport := services.Export
+	defer func() { services.Export = savedExport }()
+
+	services.Export = map[string]func(*jsonData) (int, error){
+		"ok": func(exp *jsonData) (int, error) {
+			return 3, nil
+		},
+		"broken": func(exp *jsonData) (int, error) {
+			return 0, fmt.Errorf("export failed")
+		},
+	}
+
+	svcs := []string{"ok", "broken", "unregistered"}
+	results := runExports(svcs, new(jsonData))
+
+	if len(results) != len(svcs) {
+		t.Fatalf("len(results) = %v, want %v", len(results), len(svcs))
+	}
+
+	for i, svc := range svcs {
+		if results[i].Service != svc {
+			t.Errorf("results[%d].Service = %q, want %q (order must match input)", i, results[i].Service, svc)
+		}
+	}
+
+	if results[0].Count != 3 || results[0].Err != nil {
+		t.Errorf("results[0] = %+v, want Count=3, Err=nil", results[0])
+	}
+
+	if results[1].Err == nil {
+		t.Errorf("results[1].Err = nil, want an error")
+	}
+
+	if results[2].Err == nil {
+		t.Errorf("results[2].Err = nil, want an error for an unregistered service")
+	}
+}
+
+func TestRunExportsBoundsConcurrency(t *testing.T) {
+	savedExport := services.Export
+	defer func() { services.Export = savedExport }()
+
+	var mu sync.Mutex
+	current := 0
+	maxSeen := 0
+
+	services.Export = map[string]func(*jsonData) (int, error){}
+	svcs := make([]string, 0, maxConcurrentExports*3)
+	for i := 0; i < maxConcurrentExports*3; i++ {
+		name := fmt.Sprintf("svc-%d", i)
+		svcs = append(svcs, name)
+		services.Export[name] = func(exp *jsonData) (int, error) {
+			mu.Lock()
+			current++
+			if current > maxSeen {
+				maxSeen = current
+			}
+			mu.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mu.Lock()
+			current--
+			mu.Unlock()
+			return 0, nil
+		}
+	}
+
+	runExports(svcs, new(jsonData))
+
+	if maxSeen > maxConcurrentExports {
+		t.Errorf("observed %v concurrent exports, want <= %v", maxSeen, maxConcurrentExports)
+	}
+	if maxSeen < maxConcurrentExports {
+		t.Errorf("observed %v concurrent exports, want exactly %v (semaphore should be saturated)", maxSeen, maxConcurrentExports)
+	}
+}
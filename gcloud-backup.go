@@ -22,11 +22,18 @@ import (
   "fmt"
   "strings"
 	"log"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
 
 	"encoding/json"
 	"golang.org/x/net/context"
-  "golang.org/x/oauth2/google"
   "google.golang.org/api/compute/v1"
+  "google.golang.org/api/googleapi"
+
+  "github.com/xelwarto/gcloud-backup/auth"
+  "github.com/xelwarto/gcloud-backup/storage"
 )
 
 var version = string("v0.1.0")
@@ -37,10 +44,16 @@ type Flags struct {
 	Import bool
 	Export bool
 	Readable bool
+	DryRun bool
   Service string
   Account string
 	Project string
 	Region string
+	KeyFile string
+	UseADC bool
+	Destination string
+	Rotate int
+	Verbose bool
 }
 
 type Config struct {
@@ -52,10 +65,25 @@ type Config struct {
 	} `json:"action"`
 	Project string `json:"projeoct"`
 	Region string `json:"region"`
+	KeyFile string `json:"key_file"`
+	UseADC bool `json:"use_adc,string"`
+	DryRun bool `json:"dry_run,string"`
+	Destination string `json:"destination"`
+	Rotate int `json:"rotate,string"`
+	Verbose bool `json:"verbose,string"`
 }
 
 type Services struct {
-	Export map[string]func(*jsonData)
+	Export map[string]func(*jsonData) (int, error)
+	Import map[string]func(*jsonData)
+}
+
+// Result is the outcome of running a single service's exporter.
+type Result struct {
+	Service  string
+	Err      error
+	Count    int
+	Duration time.Duration
 }
 
 type jsonData struct {
@@ -63,33 +91,85 @@ type jsonData struct {
 	Routes []*compute.Route `json:"routes,omitempty"`
 	Networks []*compute.Network `json:"networks,omitempty"`
 	Addresses map[string][]*compute.Address `json:"addresses,omitempty"`
+	Instances map[string][]*compute.Instance `json:"instances,omitempty"`
+	Disks map[string][]*compute.Disk `json:"disks,omitempty"`
+	Snapshots []*compute.Snapshot `json:"snapshots,omitempty"`
+	Images []*compute.Image `json:"images,omitempty"`
+	InstanceTemplates []*compute.InstanceTemplate `json:"instance_templates,omitempty"`
+	InstanceGroupManagers map[string][]*compute.InstanceGroupManager `json:"instance_group_managers,omitempty"`
+	Subnetworks map[string][]*compute.Subnetwork `json:"subnetworks,omitempty"`
+	TargetPools map[string][]*compute.TargetPool `json:"target_pools,omitempty"`
+	ForwardingRules map[string][]*compute.ForwardingRule `json:"forwarding_rules,omitempty"`
+	BackendServices []*compute.BackendService `json:"backend_services,omitempty"`
+	UrlMaps []*compute.UrlMap `json:"url_maps,omitempty"`
+	TargetHttpProxies []*compute.TargetHttpProxy `json:"target_http_proxies,omitempty"`
+	TargetHttpsProxies []*compute.TargetHttpsProxy `json:"target_https_proxies,omitempty"`
+	SslCertificates []*compute.SslCertificate `json:"ssl_certificates,omitempty"`
+	HealthChecks []*compute.HealthCheck `json:"health_checks,omitempty"`
 }
 
 var flags = new(Flags)
 var config = new(Config)
 var services = new(Services)
 var service *compute.Service
+var storageClient *storage.Client
 var output []byte
 
 func init() {
 	config.Action.Import = false
 	config.Action.Export = false
 
-	services.Export = make(map[string]func(*jsonData))
+	services.Export = make(map[string]func(*jsonData) (int, error))
 	services.Export["firewalls"] = exportFirewalls
 	services.Export["routes"] = exportRoutes
 	services.Export["networks"] = exportNetworks
 	services.Export["addresses"] = exportAddresses
+	services.Export["instances"] = exportInstances
+	services.Export["disks"] = exportDisks
+	services.Export["snapshots"] = exportSnapshots
+	services.Export["images"] = exportImages
+	services.Export["instanceTemplates"] = exportInstanceTemplates
+	services.Export["instanceGroupManagers"] = exportInstanceGroupManagers
+	services.Export["subnetworks"] = exportSubnetworks
+	services.Export["targetPools"] = exportTargetPools
+	services.Export["forwardingRules"] = exportForwardingRules
+	services.Export["backendServices"] = exportBackendServices
+	services.Export["urlMaps"] = exportUrlMaps
+	services.Export["targetHttpProxies"] = exportTargetHttpProxies
+	services.Export["targetHttpsProxies"] = exportTargetHttpsProxies
+	services.Export["sslCertificates"] = exportSslCertificates
+	services.Export["healthChecks"] = exportHealthChecks
+
+	services.Import = make(map[string]func(*jsonData))
+	services.Import["firewalls"] = importFirewalls
+	services.Import["routes"] = importRoutes
+	services.Import["networks"] = importNetworks
+	services.Import["addresses"] = importAddresses
 
   flag.BoolVar(&flags.Version, "version", false, "Display version information")
   flag.BoolVar(&flags.Help, "help", false, "Display this help")
 	flag.BoolVar(&flags.Export, "export", false, "Create new services export")
 	flag.BoolVar(&flags.Import, "import", false, "Start services import from backup")
 	flag.BoolVar(&flags.Readable, "readable", false, "Output JSON in readable format")
+	flag.BoolVar(&flags.DryRun, "dry-run", false, "Log import changes without calling the API")
   flag.StringVar(&flags.Service, "service", "", "List of services to export/import (comma seperated)")
   flag.StringVar(&flags.Account, "account", "", "Google SDK account username")
 	flag.StringVar(&flags.Project, "project", "", "Google SDK proect name")
 	flag.StringVar(&flags.Region, "region", "", "Specify Google compute region")
+	flag.StringVar(&flags.KeyFile, "key-file", "", "Path to a service-account JSON key file")
+	flag.BoolVar(&flags.UseADC, "use-adc", false, "Authenticate with Application Default Credentials")
+	flag.StringVar(&flags.Destination, "destination", "", "Backup destination URI (file://path.json or gs://bucket/object.json), defaults to stdin/stdout")
+	flag.IntVar(&flags.Rotate, "rotate", 0, "Keep only the N most recent backups under a gs:// destination prefix")
+	flag.BoolVar(&flags.Verbose, "verbose", false, "Log API request method, URL, status, and latency")
+
+	// Skip flag parsing and validation under `go test`: the test binary's
+	// own -test.* flags aren't registered yet at this point, and the
+	// validation below calls os.Exit on missing flags, which would abort
+	// every test in this package before it runs.
+	if testing.Testing() {
+		return
+	}
+
   flag.Parse()
 
   if flags.Version {
@@ -108,11 +188,10 @@ func init() {
     config.Service = strings.Split(flags.Service, ",")
   }
 
-  if flags.Account == "" {
-    showUsage("please specify a Google SDK user account")
-  } else {
-    config.Account = flags.Account
+  if flags.Account == "" && flags.KeyFile == "" && !flags.UseADC {
+    log.Println("No -account, -key-file, or -use-adc given, trying each credential source in turn")
   }
+  config.Account = flags.Account
 
 	if flags.Project == "" {
     showUsage("please specify a Google SDK project")
@@ -120,10 +199,20 @@ func init() {
     config.Project = flags.Project
   }
 
+	config.KeyFile = flags.KeyFile
+	config.UseADC = flags.UseADC
+	config.Destination = flags.Destination
+	config.Rotate = flags.Rotate
+	config.Verbose = flags.Verbose
+
 	if flags.Region != "" {
     config.Region = flags.Region
   }
 
+	if flags.DryRun {
+		config.DryRun = true
+	}
+
 	if flags.Import || flags.Export {
 		if flags.Import && flags.Export {
 			showUsage("please select an action - export/import")
@@ -131,7 +220,6 @@ func init() {
 			config.Action.Export = true
 		} else if flags.Import {
 			config.Action.Import = true
-			showUsage("import action not implmented")
 		}
 	} else {
 		showUsage("please select an action - export/import")
@@ -150,68 +238,687 @@ func showUsage(s ...string) {
 }
 
 func createServiceFromSDK() {
-	log.Println("Creating new client from Google SDK config")
-	sdk_config, err := google.NewSDKConfig(config.Account)
+	log.Println("Creating new compute service client")
+	svc, err := auth.NewService(context.Background(), config.Account, config.KeyFile, config.UseADC, config.Verbose)
 	if err != nil {
 		log.Fatal(err)
 	}
+	service = svc
+}
 
-	client := sdk_config.Client(context.Background())
-	service, err = compute.New(client)
+func createStorageClient() {
+	client, err := auth.NewClient(context.Background(), config.Account, config.KeyFile, config.UseADC, config.Verbose)
 	if err != nil {
 		log.Fatal(err)
 	}
+	storageClient = storage.New(client)
+}
+
+// maxConcurrentExports bounds how many exporters run at once so a large
+// service list doesn't open unbounded concurrent API calls.
+const maxConcurrentExports = 4
+
+// runExports runs each service's exporter concurrently, bounded by
+// maxConcurrentExports, and collects a Result per service regardless of
+// whether it failed so a single bad service can't abort the others.
+func runExports(svcs []string, exp *jsonData) []Result {
+	results := make([]Result, len(svcs))
+	sem := make(chan struct{}, maxConcurrentExports)
+
+	var wg sync.WaitGroup
+	for i, svc := range svcs {
+		wg.Add(1)
+		go func(i int, svc string) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			start := time.Now()
+			fn, ok := services.Export[svc]
+			if !ok {
+				results[i] = Result{Service: svc, Err: fmt.Errorf("invalid service - %v", svc), Duration: time.Since(start)}
+				return
+			}
+
+			count, err := fn(exp)
+			results[i] = Result{Service: svc, Count: count, Err: err, Duration: time.Since(start)}
+		}(i, svc)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// printSummary writes a per-service result table to stderr.
+func printSummary(results []Result) {
+	fmt.Fprintln(os.Stderr, "\nExport summary:")
+	for _, r := range results {
+		status := "OK"
+		if r.Err != nil {
+			status = fmt.Sprintf("FAILED: %v", r.Err)
+		}
+		fmt.Fprintf(os.Stderr, "  %-25v count=%-6v %-12v %v\n", r.Service, r.Count, r.Duration, status)
+	}
+}
+
+func exportNetworks(exp *jsonData) (int, error) {
+	if service == nil {
+		return 0, fmt.Errorf("compute service not initialized")
+	}
+
+	svc := service.Networks
+	err := svc.List(config.Project).Pages(context.Background(), func(page *compute.NetworkList) error {
+		exp.Networks = append(exp.Networks, page.Items...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(exp.Networks), nil
+}
+
+func exportAddresses(exp *jsonData) (int, error) {
+	if service == nil {
+		return 0, fmt.Errorf("compute service not initialized")
+	}
+
+	exp.Addresses = make(map[string][]*compute.Address)
+	svc := service.Addresses
+	err := svc.AggregatedList(config.Project).Pages(context.Background(), func(page *compute.AddressAggregatedList) error {
+		for key, value := range page.Items {
+			if len(value.Addresses) > 0 {
+				exp.Addresses[key] = append(exp.Addresses[key], value.Addresses...)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, addresses := range exp.Addresses {
+		count += len(addresses)
+	}
+	return count, nil
+}
+
+func exportFirewalls(exp *jsonData) (int, error) {
+	if service == nil {
+		return 0, fmt.Errorf("compute service not initialized")
+	}
+
+	svc := service.Firewalls
+	err := svc.List(config.Project).Pages(context.Background(), func(page *compute.FirewallList) error {
+		exp.Firewalls = append(exp.Firewalls, page.Items...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(exp.Firewalls), nil
+}
+
+func exportRoutes(exp *jsonData) (int, error) {
+	if service == nil {
+		return 0, fmt.Errorf("compute service not initialized")
+	}
+
+	svc := service.Routes
+	err := svc.List(config.Project).Pages(context.Background(), func(page *compute.RouteList) error {
+		exp.Routes = append(exp.Routes, page.Items...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(exp.Routes), nil
+}
+
+func exportInstances(exp *jsonData) (int, error) {
+	if service == nil {
+		return 0, fmt.Errorf("compute service not initialized")
+	}
+
+	exp.Instances = make(map[string][]*compute.Instance)
+	svc := service.Instances
+	err := svc.AggregatedList(config.Project).Pages(context.Background(), func(page *compute.InstanceAggregatedList) error {
+		for key, value := range page.Items {
+			if len(value.Instances) > 0 {
+				exp.Instances[key] = append(exp.Instances[key], value.Instances...)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, instances := range exp.Instances {
+		count += len(instances)
+	}
+	return count, nil
+}
+
+func exportDisks(exp *jsonData) (int, error) {
+	if service == nil {
+		return 0, fmt.Errorf("compute service not initialized")
+	}
+
+	exp.Disks = make(map[string][]*compute.Disk)
+	svc := service.Disks
+	err := svc.AggregatedList(config.Project).Pages(context.Background(), func(page *compute.DiskAggregatedList) error {
+		for key, value := range page.Items {
+			if len(value.Disks) > 0 {
+				exp.Disks[key] = append(exp.Disks[key], value.Disks...)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, disks := range exp.Disks {
+		count += len(disks)
+	}
+	return count, nil
 }
 
-func exportNetworks(exp *jsonData) {
-	if service != nil {
-		svc := service.Networks
-	  list, err := svc.List(config.Project).Do()
+func exportSnapshots(exp *jsonData) (int, error) {
+	if service == nil {
+		return 0, fmt.Errorf("compute service not initialized")
+	}
+
+	svc := service.Snapshots
+	err := svc.List(config.Project).Pages(context.Background(), func(page *compute.SnapshotList) error {
+		exp.Snapshots = append(exp.Snapshots, page.Items...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(exp.Snapshots), nil
+}
+
+func exportImages(exp *jsonData) (int, error) {
+	if service == nil {
+		return 0, fmt.Errorf("compute service not initialized")
+	}
+
+	svc := service.Images
+	err := svc.List(config.Project).Pages(context.Background(), func(page *compute.ImageList) error {
+		exp.Images = append(exp.Images, page.Items...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(exp.Images), nil
+}
+
+func exportInstanceTemplates(exp *jsonData) (int, error) {
+	if service == nil {
+		return 0, fmt.Errorf("compute service not initialized")
+	}
+
+	svc := service.InstanceTemplates
+	err := svc.List(config.Project).Pages(context.Background(), func(page *compute.InstanceTemplateList) error {
+		exp.InstanceTemplates = append(exp.InstanceTemplates, page.Items...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(exp.InstanceTemplates), nil
+}
+
+func exportInstanceGroupManagers(exp *jsonData) (int, error) {
+	if service == nil {
+		return 0, fmt.Errorf("compute service not initialized")
+	}
+
+	exp.InstanceGroupManagers = make(map[string][]*compute.InstanceGroupManager)
+	if config.Region != "" {
+		svc := service.RegionInstanceGroupManagers
+		err := svc.List(config.Project, config.Region).Pages(context.Background(), func(page *compute.RegionInstanceGroupManagerList) error {
+			exp.InstanceGroupManagers[config.Region] = append(exp.InstanceGroupManagers[config.Region], page.Items...)
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		svc := service.InstanceGroupManagers
+		err := svc.AggregatedList(config.Project).Pages(context.Background(), func(page *compute.InstanceGroupManagerAggregatedList) error {
+			for key, value := range page.Items {
+				if len(value.InstanceGroupManagers) > 0 {
+					exp.InstanceGroupManagers[key] = append(exp.InstanceGroupManagers[key], value.InstanceGroupManagers...)
+				}
+			}
+			return nil
+		})
 		if err != nil {
-	    log.Fatal(err)
-	  } else {
-			exp.Networks = list.Items
+			return 0, err
 		}
 	}
+
+	count := 0
+	for _, managers := range exp.InstanceGroupManagers {
+		count += len(managers)
+	}
+	return count, nil
+}
+
+func exportSubnetworks(exp *jsonData) (int, error) {
+	if service == nil {
+		return 0, fmt.Errorf("compute service not initialized")
+	}
+
+	exp.Subnetworks = make(map[string][]*compute.Subnetwork)
+	svc := service.Subnetworks
+	if config.Region != "" {
+		err := svc.List(config.Project, config.Region).Pages(context.Background(), func(page *compute.SubnetworkList) error {
+			exp.Subnetworks[config.Region] = append(exp.Subnetworks[config.Region], page.Items...)
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		err := svc.AggregatedList(config.Project).Pages(context.Background(), func(page *compute.SubnetworkAggregatedList) error {
+			for key, value := range page.Items {
+				if len(value.Subnetworks) > 0 {
+					exp.Subnetworks[key] = append(exp.Subnetworks[key], value.Subnetworks...)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	count := 0
+	for _, subnetworks := range exp.Subnetworks {
+		count += len(subnetworks)
+	}
+	return count, nil
+}
+
+func exportTargetPools(exp *jsonData) (int, error) {
+	if service == nil {
+		return 0, fmt.Errorf("compute service not initialized")
+	}
+
+	exp.TargetPools = make(map[string][]*compute.TargetPool)
+	svc := service.TargetPools
+	if config.Region != "" {
+		err := svc.List(config.Project, config.Region).Pages(context.Background(), func(page *compute.TargetPoolList) error {
+			exp.TargetPools[config.Region] = append(exp.TargetPools[config.Region], page.Items...)
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		err := svc.AggregatedList(config.Project).Pages(context.Background(), func(page *compute.TargetPoolAggregatedList) error {
+			for key, value := range page.Items {
+				if len(value.TargetPools) > 0 {
+					exp.TargetPools[key] = append(exp.TargetPools[key], value.TargetPools...)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	count := 0
+	for _, targetPools := range exp.TargetPools {
+		count += len(targetPools)
+	}
+	return count, nil
+}
+
+func exportForwardingRules(exp *jsonData) (int, error) {
+	if service == nil {
+		return 0, fmt.Errorf("compute service not initialized")
+	}
+
+	exp.ForwardingRules = make(map[string][]*compute.ForwardingRule)
+	svc := service.ForwardingRules
+	if config.Region != "" {
+		err := svc.List(config.Project, config.Region).Pages(context.Background(), func(page *compute.ForwardingRuleList) error {
+			exp.ForwardingRules[config.Region] = append(exp.ForwardingRules[config.Region], page.Items...)
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		err := svc.AggregatedList(config.Project).Pages(context.Background(), func(page *compute.ForwardingRuleAggregatedList) error {
+			for key, value := range page.Items {
+				if len(value.ForwardingRules) > 0 {
+					exp.ForwardingRules[key] = append(exp.ForwardingRules[key], value.ForwardingRules...)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	count := 0
+	for _, forwardingRules := range exp.ForwardingRules {
+		count += len(forwardingRules)
+	}
+	return count, nil
+}
+
+func exportBackendServices(exp *jsonData) (int, error) {
+	if service == nil {
+		return 0, fmt.Errorf("compute service not initialized")
+	}
+
+	svc := service.BackendServices
+	err := svc.List(config.Project).Pages(context.Background(), func(page *compute.BackendServiceList) error {
+		exp.BackendServices = append(exp.BackendServices, page.Items...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(exp.BackendServices), nil
+}
+
+func exportUrlMaps(exp *jsonData) (int, error) {
+	if service == nil {
+		return 0, fmt.Errorf("compute service not initialized")
+	}
+
+	svc := service.UrlMaps
+	err := svc.List(config.Project).Pages(context.Background(), func(page *compute.UrlMapList) error {
+		exp.UrlMaps = append(exp.UrlMaps, page.Items...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(exp.UrlMaps), nil
+}
+
+func exportTargetHttpProxies(exp *jsonData) (int, error) {
+	if service == nil {
+		return 0, fmt.Errorf("compute service not initialized")
+	}
+
+	svc := service.TargetHttpProxies
+	err := svc.List(config.Project).Pages(context.Background(), func(page *compute.TargetHttpProxyList) error {
+		exp.TargetHttpProxies = append(exp.TargetHttpProxies, page.Items...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(exp.TargetHttpProxies), nil
+}
+
+func exportTargetHttpsProxies(exp *jsonData) (int, error) {
+	if service == nil {
+		return 0, fmt.Errorf("compute service not initialized")
+	}
+
+	svc := service.TargetHttpsProxies
+	err := svc.List(config.Project).Pages(context.Background(), func(page *compute.TargetHttpsProxyList) error {
+		exp.TargetHttpsProxies = append(exp.TargetHttpsProxies, page.Items...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(exp.TargetHttpsProxies), nil
+}
+
+func exportSslCertificates(exp *jsonData) (int, error) {
+	if service == nil {
+		return 0, fmt.Errorf("compute service not initialized")
+	}
+
+	svc := service.SslCertificates
+	err := svc.List(config.Project).Pages(context.Background(), func(page *compute.SslCertificateList) error {
+		exp.SslCertificates = append(exp.SslCertificates, page.Items...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(exp.SslCertificates), nil
 }
 
-func exportAddresses(exp *jsonData) {
-	if service != nil {
-		exp.Addresses = make(map[string][]*compute.Address)
-		svc := service.Addresses
-	  list, err := svc.AggregatedList(config.Project).Do()
+func exportHealthChecks(exp *jsonData) (int, error) {
+	if service == nil {
+		return 0, fmt.Errorf("compute service not initialized")
+	}
+
+	svc := service.HealthChecks
+	err := svc.List(config.Project).Pages(context.Background(), func(page *compute.HealthCheckList) error {
+		exp.HealthChecks = append(exp.HealthChecks, page.Items...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(exp.HealthChecks), nil
+}
+
+// lastPathComponent returns the last segment of a resource URL, e.g.
+// ".../global/networks/default" -> "default".
+func lastPathComponent(url string) string {
+	parts := strings.Split(url, "/")
+	return parts[len(parts)-1]
+}
+
+// rewriteNetwork points a Network resource URL at the current project,
+// keeping only the network name from the backup.
+func rewriteNetwork(network string) string {
+	if network == "" {
+		return network
+	}
+	return fmt.Sprintf("projects/%v/global/networks/%v", config.Project, lastPathComponent(network))
+}
+
+// rewriteSubnetwork points a Subnetwork resource URL at the current
+// project and region, keeping only the subnetwork name from the backup.
+func rewriteSubnetwork(subnetwork, region string) string {
+	if subnetwork == "" {
+		return subnetwork
+	}
+	return fmt.Sprintf("projects/%v/regions/%v/subnetworks/%v", config.Project, region, lastPathComponent(subnetwork))
+}
+
+func isDuplicate(err error) bool {
+	if gerr, ok := err.(*googleapi.Error); ok {
+		return gerr.Code == http.StatusConflict
+	}
+	return false
+}
+
+// waitForOperation polls a compute Operation until it reaches DONE,
+// using RegionOperations when the operation is region scoped and
+// GlobalOperations otherwise.
+func waitForOperation(op *compute.Operation) error {
+	for {
+		var (
+			cur *compute.Operation
+			err error
+		)
+
+		if op.Region != "" {
+			cur, err = service.RegionOperations.Get(config.Project, lastPathComponent(op.Region), op.Name).Do()
+		} else {
+			cur, err = service.GlobalOperations.Get(config.Project, op.Name).Do()
+		}
+
 		if err != nil {
-	    log.Fatal(err)
-	  } else {
-			for key, value := range list.Items {
-				if len(value.Addresses) > 0 {
-					exp.Addresses[key] = value.Addresses
+			return err
+		}
+
+		if cur.Status == "DONE" {
+			if cur.Error != nil {
+				msg := "unknown error"
+				if len(cur.Error.Errors) > 0 {
+					msg = cur.Error.Errors[0].Message
 				}
+				return fmt.Errorf("operation %v failed: %v", cur.Name, msg)
 			}
+			return nil
+		}
+
+		time.Sleep(2 * time.Second)
+	}
+}
+
+func importFirewalls(imp *jsonData) {
+	if service == nil {
+		return
+	}
+
+	svc := service.Firewalls
+	for _, fw := range imp.Firewalls {
+		fw.Id = 0
+		fw.CreationTimestamp = ""
+		fw.SelfLink = ""
+		fw.Network = rewriteNetwork(fw.Network)
+
+		if config.DryRun {
+			log.Printf("[dry-run] would import firewall %v", fw.Name)
+			continue
+		}
+
+		op, err := svc.Insert(config.Project, fw).Do()
+		if isDuplicate(err) {
+			log.Printf("firewall %v already exists, patching", fw.Name)
+			op, err = svc.Patch(config.Project, fw.Name, fw).Do()
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := waitForOperation(op); err != nil {
+			log.Fatal(err)
 		}
 	}
 }
 
-func exportFirewalls(exp *jsonData) {
-	if service != nil {
-		svc := service.Firewalls
-	  list, err := svc.List(config.Project).Do()
+func importRoutes(imp *jsonData) {
+	if service == nil {
+		return
+	}
+
+	svc := service.Routes
+	for _, route := range imp.Routes {
+		route.Id = 0
+		route.CreationTimestamp = ""
+		route.SelfLink = ""
+		route.Network = rewriteNetwork(route.Network)
+
+		if config.DryRun {
+			log.Printf("[dry-run] would import route %v", route.Name)
+			continue
+		}
+
+		op, err := svc.Insert(config.Project, route).Do()
+		if isDuplicate(err) {
+			log.Printf("route %v already exists, skipping", route.Name)
+			continue
+		}
 		if err != nil {
-	    log.Fatal(err)
-	  } else {
-			exp.Firewalls = list.Items
+			log.Fatal(err)
+		}
+		if err := waitForOperation(op); err != nil {
+			log.Fatal(err)
 		}
 	}
 }
 
-func exportRoutes(exp *jsonData) {
-	if service != nil {
-		svc := service.Routes
-	  list, err := svc.List(config.Project).Do()
+func importNetworks(imp *jsonData) {
+	if service == nil {
+		return
+	}
+
+	svc := service.Networks
+	for _, network := range imp.Networks {
+		network.Id = 0
+		network.CreationTimestamp = ""
+		network.SelfLink = ""
+
+		if config.DryRun {
+			log.Printf("[dry-run] would import network %v", network.Name)
+			continue
+		}
+
+		op, err := svc.Insert(config.Project, network).Do()
+		if isDuplicate(err) {
+			log.Printf("network %v already exists, skipping", network.Name)
+			continue
+		}
 		if err != nil {
-	    log.Fatal(err)
-	  } else {
-			exp.Routes = list.Items
+			log.Fatal(err)
+		}
+		if err := waitForOperation(op); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func importAddresses(imp *jsonData) {
+	if service == nil {
+		return
+	}
+
+	svc := service.Addresses
+	for region, addresses := range imp.Addresses {
+		region := lastPathComponent(region)
+		for _, addr := range addresses {
+			addr.Id = 0
+			addr.CreationTimestamp = ""
+			addr.SelfLink = ""
+			addr.Network = rewriteNetwork(addr.Network)
+			addr.Subnetwork = rewriteSubnetwork(addr.Subnetwork, region)
+
+			if config.DryRun {
+				log.Printf("[dry-run] would import address %v in %v", addr.Name, region)
+				continue
+			}
+
+			op, err := svc.Insert(config.Project, region, addr).Do()
+			if isDuplicate(err) {
+				log.Printf("address %v already exists in %v, skipping", addr.Name, region)
+				continue
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := waitForOperation(op); err != nil {
+				log.Fatal(err)
+			}
 		}
 	}
 }
@@ -222,11 +929,13 @@ func main() {
 		log.Printf("Starting export process of %v", config.Service)
 		export := new(jsonData)
 		createServiceFromSDK()
-		for _, svc := range config.Service {
-			if _, ok := services.Export[svc]; ok {
-				services.Export[svc](export)
-			} else {
-				log.Printf("Error: invalid service - %v", svc)
+		results := runExports(config.Service, export)
+
+		failed := 0
+		for _, r := range results {
+			if r.Err != nil {
+				log.Printf("Error: %v export failed: %v", r.Service, r.Err)
+				failed++
 			}
 		}
 
@@ -243,8 +952,71 @@ func main() {
 			}
 			output = b
 		}
-		os.Stdout.Write(output)
+
+		if config.Destination == "" {
+			os.Stdout.Write(output)
+		} else {
+			createStorageClient()
+
+			dest := config.Destination
+			if config.Rotate > 0 {
+				dest = storage.RotatedURI(config.Destination, time.Now())
+			}
+
+			w, err := storageClient.Writer(context.Background(), dest)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if _, err := w.Write(output); err != nil {
+				log.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				log.Fatal(err)
+			}
+
+			if config.Rotate > 0 {
+				if err := storageClient.Rotate(context.Background(), config.Destination, config.Rotate); err != nil {
+					log.Fatal(err)
+				}
+			}
+		}
+
+		printSummary(results)
+		if failed > 0 {
+			os.Exit(2)
+		}
 	} else if config.Action.Import {
 		log.Printf("Starting import process of %v", config.Service)
+		if config.DryRun {
+			log.Printf("Running in dry-run mode, no changes will be made")
+		}
+
+		imp := new(jsonData)
+		if config.Destination == "" {
+			if err := json.NewDecoder(os.Stdin).Decode(imp); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			createStorageClient()
+
+			r, err := storageClient.Reader(context.Background(), config.Destination)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer r.Close()
+
+			if err := json.NewDecoder(r).Decode(imp); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		createServiceFromSDK()
+		for _, svc := range config.Service {
+			if _, ok := services.Import[svc]; ok {
+				services.Import[svc](imp)
+			} else {
+				log.Printf("Error: invalid service - %v", svc)
+			}
+		}
 	}
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+func TestLastPathComponent(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://www.googleapis.com/compute/v1/projects/p/global/networks/default", "default"},
+		{"projects/p/regions/us-central1/subnetworks/web", "web"},
+		{"default", "default"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := lastPathComponent(tt.url); got != tt.want {
+			t.Errorf("lastPathComponent(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestRewriteNetwork(t *testing.T) {
+	config.Project = "new-project"
+	defer func() { config.Project = "" }()
+
+	if got := rewriteNetwork(""); got != "" {
+		t.Errorf("rewriteNetwork(\"\") = %q, want empty", got)
+	}
+
+	want := "projects/new-project/global/networks/default"
+	got := rewriteNetwork("projects/old-project/global/networks/default")
+	if got != want {
+		t.Errorf("rewriteNetwork(...) = %q, want %q", got, want)
+	}
+}
+
+func TestRewriteSubnetwork(t *testing.T) {
+	config.Project = "new-project"
+	defer func() { config.Project = "" }()
+
+	if got := rewriteSubnetwork("", "us-central1"); got != "" {
+		t.Errorf("rewriteSubnetwork(\"\", ...) = %q, want empty", got)
+	}
+
+	want := "projects/new-project/regions/us-central1/subnetworks/web"
+	got := rewriteSubnetwork("projects/old-project/regions/us-central1/subnetworks/web", "us-central1")
+	if got != want {
+		t.Errorf("rewriteSubnetwork(...) = %q, want %q", got, want)
+	}
+}
+
+func TestIsDuplicate(t *testing.T) {
+	if isDuplicate(nil) {
+		t.Error("isDuplicate(nil) = true, want false")
+	}
+	if isDuplicate(fmt.Errorf("boom")) {
+		t.Error("isDuplicate(plain error) = true, want false")
+	}
+	if !isDuplicate(&googleapi.Error{Code: http.StatusConflict}) {
+		t.Error("isDuplicate(409) = false, want true")
+	}
+	if isDuplicate(&googleapi.Error{Code: http.StatusNotFound}) {
+		t.Error("isDuplicate(404) = true, want false")
+	}
+}
+
+func TestWaitForOperation(t *testing.T) {
+	config.Project = "test-project"
+	defer func() { config.Project = "" }()
+
+	tests := []struct {
+		name    string
+		op      *compute.Operation
+		handler http.HandlerFunc
+		wantErr string
+	}{
+		{
+			name: "global success",
+			op:   &compute.Operation{Name: "op-1"},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(&compute.Operation{Name: "op-1", Status: "DONE"})
+			},
+		},
+		{
+			name: "region success",
+			op:   &compute.Operation{Name: "op-2", Region: "https://www.googleapis.com/compute/v1/projects/test-project/regions/us-central1"},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(&compute.Operation{Name: "op-2", Status: "DONE"})
+			},
+		},
+		{
+			name: "failure with message",
+			op:   &compute.Operation{Name: "op-3"},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(&compute.Operation{
+					Name:   "op-3",
+					Status: "DONE",
+					Error: &compute.OperationError{
+						Errors: []*compute.OperationErrorErrors{{Message: "quota exceeded"}},
+					},
+				})
+			},
+			wantErr: "operation op-3 failed: quota exceeded",
+		},
+		{
+			name: "failure with no error detail",
+			op:   &compute.Operation{Name: "op-4"},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(&compute.Operation{
+					Name:   "op-4",
+					Status: "DONE",
+					Error:  &compute.OperationError{},
+				})
+			},
+			wantErr: "operation op-4 failed: unknown error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			svc, err := compute.New(http.DefaultClient)
+			if err != nil {
+				t.Fatalf("compute.New: %v", err)
+			}
+			svc.BasePath = srv.URL + "/"
+			service = svc
+			defer func() { service = nil }()
+
+			err = waitForOperation(tt.op)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("waitForOperation: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("waitForOperation error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
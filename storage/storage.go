@@ -0,0 +1,235 @@
+/*
+Copyright 2016 Ted Elwartowski <xelwarto.pub@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package storage reads and writes backup JSON to a file:// or gs://
+// destination URI.
+package storage
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	gcs "cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// Client reads and writes backup destinations, authenticating GCS access
+// with the HTTP client it was given.
+type Client struct {
+	http *http.Client
+}
+
+// New returns a Client that authenticates GCS access with http.
+func New(http *http.Client) *Client {
+	return &Client{http: http}
+}
+
+// Writer opens a WriteCloser for uri, which must be a file:// or gs://
+// URI. When uri ends in .gz the stream is gzip compressed.
+func (c *Client) Writer(ctx context.Context, uri string) (io.WriteCloser, error) {
+	scheme, bucket, object, err := parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var w io.WriteCloser
+	switch scheme {
+	case "file":
+		f, err := os.Create(object)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	case "gs":
+		client, err := gcs.NewClient(ctx, option.WithHTTPClient(c.http))
+		if err != nil {
+			return nil, err
+		}
+
+		obj := client.Bucket(bucket).Object(object).NewWriter(ctx)
+		obj.ContentType = "application/json"
+		w = obj
+	default:
+		return nil, fmt.Errorf("storage: unsupported destination scheme %q", scheme)
+	}
+
+	if strings.HasSuffix(object, ".gz") {
+		w = gzipWriteCloser{gzip.NewWriter(w), w}
+	}
+
+	return w, nil
+}
+
+// Reader opens a ReadCloser for uri, which must be a file:// or gs://
+// URI. When uri ends in .gz the stream is gunzipped.
+func (c *Client) Reader(ctx context.Context, uri string) (io.ReadCloser, error) {
+	scheme, bucket, object, err := parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.ReadCloser
+	switch scheme {
+	case "file":
+		f, err := os.Open(object)
+		if err != nil {
+			return nil, err
+		}
+		r = f
+	case "gs":
+		client, err := gcs.NewClient(ctx, option.WithHTTPClient(c.http))
+		if err != nil {
+			return nil, err
+		}
+
+		obj, err := client.Bucket(bucket).Object(object).NewReader(ctx)
+		if err != nil {
+			return nil, err
+		}
+		r = obj
+	default:
+		return nil, fmt.Errorf("storage: unsupported destination scheme %q", scheme)
+	}
+
+	if strings.HasSuffix(object, ".gz") {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r = gzipReadCloser{gr, r}
+	}
+
+	return r, nil
+}
+
+// Rotate keeps the n most recently named objects under prefix (a
+// gs://bucket/prefix/ URI) and deletes the rest. Object names under a
+// rotated prefix are UTC timestamps of the form YYYY-MM-DDTHH-MM-SSZ (see
+// RotatedURI), so lexicographic order is also chronological order.
+func (c *Client) Rotate(ctx context.Context, prefix string, n int) error {
+	scheme, bucket, object, err := parseURI(prefix)
+	if err != nil {
+		return err
+	}
+	if scheme != "gs" {
+		return fmt.Errorf("storage: rotate is only supported for gs:// destinations")
+	}
+
+	client, err := gcs.NewClient(ctx, option.WithHTTPClient(c.http))
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	it := client.Bucket(bucket).Objects(ctx, &gcs.Query{Prefix: rotatePrefix(object)})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		names = append(names, attrs.Name)
+	}
+
+	sort.Strings(names)
+	if len(names) <= n {
+		return nil
+	}
+
+	for _, name := range names[:len(names)-n] {
+		if err := client.Bucket(bucket).Object(name).Delete(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rotatePrefix normalizes object to a /-terminated prefix, the same way
+// RotatedURI builds object names, so a raw GCS prefix match can't sweep
+// up a sibling object or rotation target that merely starts with the
+// same string (e.g. "backups" would also match "backups-staging/..."
+// or "backupsOLD.json").
+func rotatePrefix(object string) string {
+	return strings.TrimSuffix(object, "/") + "/"
+}
+
+// RotatedURI returns the timestamped object name a --rotate backup
+// should be written to: gs://bucket/prefix/YYYY-MM-DDTHH-MM-SSZ.json.
+func RotatedURI(prefix string, now time.Time) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return fmt.Sprintf("%v/%v.json", prefix, now.UTC().Format("2006-01-02T15-04-05Z"))
+}
+
+func parseURI(uri string) (scheme, bucket, object string, err error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	switch u.Scheme {
+	case "file":
+		return "file", "", u.Host + u.Path, nil
+	case "gs":
+		return "gs", u.Host, strings.TrimPrefix(u.Path, "/"), nil
+	default:
+		return "", "", "", fmt.Errorf("storage: destination %q must be a file:// or gs:// URI", uri)
+	}
+}
+
+type gzipWriteCloser struct {
+	gz  *gzip.Writer
+	dst io.WriteCloser
+}
+
+func (w gzipWriteCloser) Write(p []byte) (int, error) {
+	return w.gz.Write(p)
+}
+
+func (w gzipWriteCloser) Close() error {
+	if err := w.gz.Close(); err != nil {
+		w.dst.Close()
+		return err
+	}
+	return w.dst.Close()
+}
+
+type gzipReadCloser struct {
+	gz  *gzip.Reader
+	src io.ReadCloser
+}
+
+func (r gzipReadCloser) Read(p []byte) (int, error) {
+	return r.gz.Read(p)
+}
+
+func (r gzipReadCloser) Close() error {
+	r.gz.Close()
+	return r.src.Close()
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestParseURI(t *testing.T) {
+	tests := []struct {
+		uri        string
+		wantScheme string
+		wantBucket string
+		wantObject string
+		wantErr    bool
+	}{
+		{"file:///tmp/backup.json", "file", "", "/tmp/backup.json", false},
+		{"file://backup.json", "file", "", "backup.json", false},
+		{"gs://my-bucket/prefix/backup.json", "gs", "my-bucket", "prefix/backup.json", false},
+		{"gs://my-bucket/backup.json.gz", "gs", "my-bucket", "backup.json.gz", false},
+		{"s3://my-bucket/backup.json", "", "", "", true},
+		{"not-a-uri-at-all", "", "", "", true},
+	}
+
+	for _, tt := range tests {
+		scheme, bucket, object, err := parseURI(tt.uri)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseURI(%q): expected error, got none", tt.uri)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseURI(%q): unexpected error: %v", tt.uri, err)
+			continue
+		}
+		if scheme != tt.wantScheme || bucket != tt.wantBucket || object != tt.wantObject {
+			t.Errorf("parseURI(%q) = (%q, %q, %q), want (%q, %q, %q)",
+				tt.uri, scheme, bucket, object, tt.wantScheme, tt.wantBucket, tt.wantObject)
+		}
+	}
+}
+
+func TestRotatePrefixIsolatesSiblings(t *testing.T) {
+	tests := []struct {
+		a, b string
+	}{
+		{"backups", "backups-staging"},
+		{"backups", "backupsOLD.json"},
+		{"gcloud-backup/daily", "gcloud-backup/daily-archive"},
+	}
+
+	for _, tt := range tests {
+		pa, pb := rotatePrefix(tt.a), rotatePrefix(tt.b)
+		if strings.HasPrefix(pb, pa) || strings.HasPrefix(pa, pb) {
+			t.Errorf("rotatePrefix(%q)=%q and rotatePrefix(%q)=%q are not isolated", tt.a, pa, tt.b, pb)
+		}
+	}
+}
+
+func TestRotatedURI(t *testing.T) {
+	now := time.Date(2026, 7, 26, 9, 5, 3, 0, time.UTC)
+
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"gs://my-bucket/backups", "gs://my-bucket/backups/2026-07-26T09-05-03Z.json"},
+		{"gs://my-bucket/backups/", "gs://my-bucket/backups/2026-07-26T09-05-03Z.json"},
+	}
+
+	for _, tt := range tests {
+		got := RotatedURI(tt.prefix, now)
+		if got != tt.want {
+			t.Errorf("RotatedURI(%q, %v) = %q, want %q", tt.prefix, now, got, tt.want)
+		}
+	}
+}
+
+func TestWriterReaderRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+	}{
+		{"plain", "backup.json"},
+		{"gzip", "backup.json.gz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "storage-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			uri := "file://" + filepath.Join(dir, tt.file)
+			c := New(nil)
+			ctx := context.Background()
+
+			w, err := c.Writer(ctx, uri)
+			if err != nil {
+				t.Fatalf("Writer: %v", err)
+			}
+			if _, err := w.Write([]byte(`{"hello":"world"}`)); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			r, err := c.Reader(ctx, uri)
+			if err != nil {
+				t.Fatalf("Reader: %v", err)
+			}
+			defer r.Close()
+
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+			if string(got) != `{"hello":"world"}` {
+				t.Errorf("got %q, want %q", got, `{"hello":"world"}`)
+			}
+		})
+	}
+}
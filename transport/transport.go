@@ -0,0 +1,149 @@
+/*
+Copyright 2016 Ted Elwartowski <xelwarto.pub@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package transport wraps an http.RoundTripper with logging and retry
+// behavior for calls to the Google compute API.
+package transport
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const maxReauthAttempts = 3
+
+var retryableStatus = map[int]bool{
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+var idempotentMethod = map[string]bool{
+	"GET":  true,
+	"HEAD": true,
+}
+
+// invalidatableTokenSource is implemented by token sources that can be
+// forced to mint a fresh token on the next Token() call, bypassing any
+// expiry-based cache they wrap internally. A 401 with a locally
+// still-valid cached token (revocation, wrong scope, clock skew) would
+// otherwise just hand back the same stale token on every reauth attempt.
+type invalidatableTokenSource interface {
+	oauth2.TokenSource
+	Invalidate()
+}
+
+// RoundTripper logs every request and retries idempotent calls that fail
+// with a transient 5xx/429, or re-authenticates once per failure on a
+// 401, up to MaxRetries/maxReauthAttempts attempts.
+type RoundTripper struct {
+	Base        http.RoundTripper
+	TokenSource oauth2.TokenSource
+	Verbose     bool
+	MaxRetries  int
+	MaxBackoff  time.Duration
+}
+
+// New returns a RoundTripper that wraps base, retrying idempotent
+// requests up to maxRetries times and refreshing token from source on a
+// 401 response.
+func New(base http.RoundTripper, source oauth2.TokenSource, verbose bool, maxRetries int) *RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &RoundTripper{
+		Base:        base,
+		TokenSource: source,
+		Verbose:     verbose,
+		MaxRetries:  maxRetries,
+		MaxBackoff:  30 * time.Second,
+	}
+}
+
+func (t *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	reauthAttempts := 0
+	for attempt := 0; ; attempt++ {
+		if body != nil {
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		start := time.Now()
+		resp, err := t.Base.RoundTrip(req)
+		latency := time.Since(start)
+
+		if t.Verbose {
+			status := 0
+			if resp != nil {
+				status = resp.StatusCode
+			}
+			log.Printf("[transport] %v %v -> %v (%v)", req.Method, req.URL, status, latency)
+		}
+
+		if err != nil {
+			return resp, err
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && reauthAttempts < maxReauthAttempts {
+			reauthAttempts++
+			resp.Body.Close()
+
+			if inv, ok := t.TokenSource.(invalidatableTokenSource); ok {
+				inv.Invalidate()
+			}
+			if _, err := t.TokenSource.Token(); err != nil {
+				return resp, err
+			}
+
+			continue
+		}
+
+		if retryableStatus[resp.StatusCode] && idempotentMethod[req.Method] && attempt < t.MaxRetries {
+			resp.Body.Close()
+			time.Sleep(backoff(attempt, t.MaxBackoff))
+			continue
+		}
+
+		return resp, nil
+	}
+}
+
+// backoff returns an exponential delay with jitter, capped at max.
+func backoff(attempt int, max time.Duration) time.Duration {
+	d := time.Duration(1<<uint(attempt)) * 500 * time.Millisecond
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2+1)))
+}
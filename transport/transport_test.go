@@ -0,0 +1,143 @@
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestBackoff(t *testing.T) {
+	max := 2 * time.Second
+
+	for attempt := 0; attempt < 10; attempt++ {
+		d := backoff(attempt, max)
+		if d < 0 {
+			t.Fatalf("backoff(%d, %v) = %v, want >= 0", attempt, max, d)
+		}
+		if d > max {
+			t.Fatalf("backoff(%d, %v) = %v, want <= %v", attempt, max, d, max)
+		}
+	}
+}
+
+// stubTransport serves canned responses for each call in turn, counting
+// the calls it sees.
+type stubTransport struct {
+	responses []int
+	calls     int
+}
+
+func (s *stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	status := s.responses[s.calls]
+	s.calls++
+	return &http.Response{
+		StatusCode: status,
+		Body:       http.NoBody,
+		Header:     make(http.Header),
+	}, nil
+}
+
+// stubTokenSource counts how many times Token and Invalidate are
+// called, implementing invalidatableTokenSource.
+type stubTokenSource struct {
+	calls           int
+	invalidateCalls int
+}
+
+func (s *stubTokenSource) Token() (*oauth2.Token, error) {
+	s.calls++
+	return &oauth2.Token{AccessToken: "stub"}, nil
+}
+
+func (s *stubTokenSource) Invalidate() {
+	s.invalidateCalls++
+}
+
+func TestRoundTripRetriesOnTransientStatus(t *testing.T) {
+	base := &stubTransport{responses: []int{http.StatusServiceUnavailable, http.StatusOK}}
+	rt := New(base, &stubTokenSource{}, false, 3)
+	rt.MaxBackoff = time.Millisecond
+
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("final status = %v, want %v", resp.StatusCode, http.StatusOK)
+	}
+	if base.calls != 2 {
+		t.Errorf("base.calls = %v, want 2", base.calls)
+	}
+}
+
+func TestRoundTripDoesNotRetryNonIdempotent(t *testing.T) {
+	base := &stubTransport{responses: []int{http.StatusServiceUnavailable, http.StatusOK}}
+	rt := New(base, &stubTokenSource{}, false, 3)
+	rt.MaxBackoff = time.Millisecond
+
+	req := httptest.NewRequest("POST", "http://example.com", nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status = %v, want %v (no retry on POST)", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+	if base.calls != 1 {
+		t.Errorf("base.calls = %v, want 1", base.calls)
+	}
+}
+
+func TestRoundTripReauthsOnUnauthorized(t *testing.T) {
+	base := &stubTransport{responses: []int{
+		http.StatusUnauthorized,
+		http.StatusUnauthorized,
+		http.StatusOK,
+	}}
+	ts := &stubTokenSource{}
+	rt := New(base, ts, false, 3)
+
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("final status = %v, want %v", resp.StatusCode, http.StatusOK)
+	}
+	if ts.calls != 2 {
+		t.Errorf("ts.calls = %v, want 2", ts.calls)
+	}
+	if ts.invalidateCalls != 2 {
+		t.Errorf("ts.invalidateCalls = %v, want 2", ts.invalidateCalls)
+	}
+}
+
+func TestRoundTripBoundsReauthAttempts(t *testing.T) {
+	responses := make([]int, maxReauthAttempts+2)
+	for i := range responses {
+		responses[i] = http.StatusUnauthorized
+	}
+	base := &stubTransport{responses: responses}
+	ts := &stubTokenSource{}
+	rt := New(base, ts, false, 0)
+
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("final status = %v, want %v", resp.StatusCode, http.StatusUnauthorized)
+	}
+	if ts.calls != maxReauthAttempts {
+		t.Errorf("ts.calls = %v, want %v", ts.calls, maxReauthAttempts)
+	}
+	if ts.invalidateCalls != maxReauthAttempts {
+		t.Errorf("ts.invalidateCalls = %v, want %v", ts.invalidateCalls, maxReauthAttempts)
+	}
+}